@@ -0,0 +1,64 @@
+package sms // import "github.com/mndrix/sms-over-xmpp"
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	backoffMin = 1 * time.Second
+	backoffMax = 5 * time.Minute
+
+	// backoffResetAfter is how long a process has to stay up before a
+	// subsequent death is treated as a fresh failure (starting back at
+	// backoffMin) rather than another step up the exponential curve.
+	backoffResetAfter = 1 * time.Minute
+)
+
+// backoff computes jittered exponential delays for restarting a
+// supervised process or retrying a failed send, so a flapping
+// connection or destination backs off instead of being hammered.  The
+// zero value uses backoffMin/backoffMax; set Min/Max for a different
+// range (e.g. the outbound SMS queue's 1s..10m).  It is not safe for
+// concurrent use; callers keep one per supervised process or
+// destination.
+type backoff struct {
+	Min, Max time.Duration
+
+	attempt  int
+	lastDown time.Time
+}
+
+// next reports the delay to wait before the next attempt, and records
+// that a failure is happening now.  Call started when the prior attempt
+// (or prior process run) began, so next can tell a genuine flap from
+// one that succeeded for a while before failing.
+func (b *backoff) next(started time.Time) time.Duration {
+	min, max := b.Min, b.Max
+	if min <= 0 {
+		min = backoffMin
+	}
+	if max <= 0 {
+		max = backoffMax
+	}
+
+	if !b.lastDown.IsZero() && started.Before(b.lastDown) {
+		// started predates our last recorded failure; nothing to
+		// compare against yet, treat as a fresh failure.
+		b.attempt = 0
+	} else if time.Since(started) >= backoffResetAfter {
+		b.attempt = 0
+	}
+
+	delay := min << uint(b.attempt)
+	if delay > max || delay <= 0 {
+		delay = max
+	}
+	b.attempt++
+	b.lastDown = time.Now()
+
+	// full jitter: a random delay in [0, delay), per the AWS
+	// architecture blog's recommendation for backing off against a
+	// shared service without synchronizing retries across clients.
+	return time.Duration(rand.Int63n(int64(delay)))
+}
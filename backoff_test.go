@@ -0,0 +1,45 @@
+package sms
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffStaysWithinBounds(t *testing.T) {
+	b := backoff{Min: 1, Max: 100}
+	started := time.Now()
+
+	for i := 0; i < 20; i++ {
+		delay := b.next(started)
+		if delay < 0 || delay >= b.Max {
+			t.Fatalf("attempt %d: got delay %v, want [0, %v)", i, delay, b.Max)
+		}
+	}
+}
+
+func TestBackoffResetsAfterUptime(t *testing.T) {
+	b := backoff{Min: 1, Max: 100}
+
+	for i := 0; i < 10; i++ {
+		b.next(time.Now())
+	}
+	if b.attempt == 0 {
+		t.Fatal("attempt never advanced")
+	}
+
+	// started far enough in the past that next() should treat this as
+	// having stayed up past backoffResetAfter, and reset the curve.
+	longAgo := time.Now().Add(-2 * backoffResetAfter)
+	b.next(longAgo)
+	if b.attempt != 1 {
+		t.Fatalf("got attempt=%d after a long uptime, want 1 (reset then incremented)", b.attempt)
+	}
+}
+
+func TestBackoffDefaultsWhenUnset(t *testing.T) {
+	var b backoff
+	delay := b.next(time.Now())
+	if delay < 0 || delay >= backoffMax {
+		t.Fatalf("got delay %v, want [0, %v) using the package defaults", delay, backoffMax)
+	}
+}
@@ -2,7 +2,6 @@ package sms // import "github.com/mndrix/sms-over-xmpp"
 
 import (
 	"fmt"
-	"log"
 	"sync"
 	"time"
 
@@ -41,6 +40,48 @@ type Component struct {
 	// txXmppCh is a channel connecting Gateway->XMPP. It communicates
 	// outgoing XMPP messages.
 	txXmppCh chan *xco.Message
+
+	// smQueue holds outbound stanzas that haven't yet been acked by
+	// the XMPP server (XEP-0198).  It's kept on Component, rather than
+	// on xmppProcess, so a restarted xmppProcess can still attempt to
+	// resume the previous stream and replay whatever didn't make it.
+	smQueue *smQueue
+
+	// router dispatches every inbound XMPP stanza, not just <message/>
+	// ones.  It's built once, at construction, so embedders using
+	// sms.Main can register their own handlers (disco, vCard, ad-hoc
+	// commands, PubSub, ...) by implementing CanRouter on their Config.
+	router *Router
+
+	// mucRooms persists SMS-group-thread -> MUC-room assignments across
+	// mucProcess restarts.
+	mucRooms *mucRoomStore
+
+	// muc is the running MUC process, so sms2xmpp can hand it inbound
+	// group messages to post.  It's nil until runMucProcess's first
+	// call, and gets replaced every time the MUC process restarts --
+	// mucMu guards it since it's written from Main's supervisor
+	// goroutine and read from the gateway goroutine.
+	mucMu sync.Mutex
+	muc   *mucProcess
+
+	// txMucPresenceCh is a channel connecting Gateway/MUC->XMPP. It
+	// communicates outgoing presence stanzas (room joins, etc.)
+	// generated by mucProcess.  It's chan interface{}, not chan
+	// *xco.Presence, because a MUC join presence needs a
+	// muc-namespaced <x/> child go-xco's Presence type has no field
+	// for; mucProcess sends its own envelope type instead.
+	txMucPresenceCh chan interface{}
+
+	// logger is where every process on Component reports what it's
+	// doing, instead of calling the stdlib "log" package directly.
+	logger Logger
+
+	// outboundSms is the ack/nack-driven retry queue for outbound SMS
+	// sends.  It's nil if the configured SmsProvider doesn't implement
+	// RetryableProvider, in which case xmpp2sms falls back to sending
+	// directly through the provider with no retry.
+	outboundSms *outboundSmsQueue
 }
 
 // Main runs a component using the given configuration.  It's the main
@@ -52,28 +93,89 @@ func Main(config Config) {
 	sc.rxSmsCh = make(chan rxSms)
 	sc.rxXmppCh = make(chan *xco.Message)
 	sc.txXmppCh = make(chan *xco.Message)
+	sc.smQueue = newSmQueue(0)
+	sc.smQueue.onEvict = sc.smQueueEvicted
+
+	if cfg, ok := config.(CanLogger); ok {
+		sc.logger = cfg.Logger()
+	} else {
+		sc.logger = NewStdLogger()
+	}
+
+	if cfg, ok := config.(CanRouter); ok {
+		sc.router = cfg.Router()
+	} else {
+		sc.router = NewRouter()
+	}
+	sc.router.SetLogger(sc.logger)
+	sc.router.HandleFunc("message", "", "", sc.handleXmppMessage)
+
+	sc.mucRooms = newMucRoomStore()
+	sc.txMucPresenceCh = make(chan interface{})
+
+	if provider, err := config.SmsProvider(); err == nil {
+		if rp, ok := provider.(RetryableProvider); ok {
+			sc.outboundSms = newOutboundSmsQueue(rp, sc.logger, 4)
+			sc.outboundSms.onPermanentFailure = sc.smsPermanentlyFailed
+		}
+	}
+
+	_, mucSupported := config.(CanMucService)
 
 	// start processes running
+	var gatewayBackoff, httpBackoff, xmppBackoff, mucBackoff backoff
+	gatewayStarted := time.Now()
 	gatewayDead := sc.runGatewayProcess()
+	xmppStarted := time.Now()
 	xmppDead := sc.runXmppProcess()
+	httpStarted := time.Now()
 	httpDead := sc.runHttpProcess()
+	var mucStarted time.Time
+	var mucDead <-chan struct{}
+	if mucSupported {
+		mucStarted = time.Now()
+		mucDead = sc.runMucProcess()
+	}
 
 	for {
 		select {
 		case _ = <-gatewayDead:
-			log.Printf("Gateway died. Restarting")
+			sc.logger.Warn("gateway died, restarting")
+			time.Sleep(gatewayBackoff.next(gatewayStarted))
+			gatewayStarted = time.Now()
 			gatewayDead = sc.runGatewayProcess()
 		case _ = <-httpDead:
-			log.Printf("HTTP died. Restarting")
+			sc.logger.Warn("HTTP died, restarting")
+			time.Sleep(httpBackoff.next(httpStarted))
+			httpStarted = time.Now()
 			httpDead = sc.runHttpProcess()
 		case _ = <-xmppDead:
-			log.Printf("XMPP died. Restarting")
-			time.Sleep(1 * time.Second) // don't hammer server
+			sc.logger.Warn("XMPP died, restarting")
+			time.Sleep(xmppBackoff.next(xmppStarted))
+			xmppStarted = time.Now()
 			xmppDead = sc.runXmppProcess()
+		case _ = <-mucDead:
+			sc.logger.Warn("MUC died, restarting")
+			time.Sleep(mucBackoff.next(mucStarted))
+			mucStarted = time.Now()
+			mucDead = sc.runMucProcess()
 		}
 	}
 }
 
+// handleXmppMessage is the router handler that replaces the old direct
+// read off rxXmppCh: it's how <message/> stanzas still reach the
+// gateway, except now that's just one registration among many instead
+// of the only thing xmppProcess knows how to do with a stanza.
+func (sc *Component) handleXmppMessage(sender Sender, stanza interface{}) error {
+	msg, ok := stanza.(*xco.Message)
+	if !ok {
+		return nil
+	}
+	sc.rxXmppCh <- msg
+	return nil
+}
+
 // runGatewayProcess starts the Gateway process. it translates between
 // the HTTP and XMPP processes.
 func (sc *Component) runGatewayProcess() <-chan struct{} {
@@ -81,6 +183,10 @@ func (sc *Component) runGatewayProcess() <-chan struct{} {
 	go func(rxSmsCh <-chan rxSms, rxXmppCh <-chan *xco.Message) {
 		defer func() { close(healthCh) }()
 
+		if cfg, ok := sc.config.(CanOnGatewayReady); ok {
+			cfg.OnGatewayReady()
+		}
+
 		for {
 			select {
 			case rxSms := <-rxSmsCh:
@@ -93,15 +199,17 @@ func (sc *Component) runGatewayProcess() <-chan struct{} {
 					case smsDelivered:
 						errCh <- sc.smsDelivered(x.id)
 					default:
-						log.Panicf("unexpected SMS status: %d", x.status)
+						sc.logger.Error("unexpected SMS status", "status", x.status)
+						panic(fmt.Sprintf("unexpected SMS status: %d", x.status))
 					}
 				default:
-					log.Panicf("unexpected rxSms type: %#v", rxSms)
+					sc.logger.Error("unexpected rxSms type", "type", fmt.Sprintf("%#v", rxSms))
+					panic(fmt.Sprintf("unexpected rxSms type: %#v", rxSms))
 				}
 			case msg := <-rxXmppCh:
 				err := sc.xmpp2sms(msg)
 				if err != nil {
-					log.Printf("ERROR: converting XMPP to SMS: %s", err)
+					sc.logger.Error("converting XMPP to SMS", "error", err)
 					return
 				}
 			}
@@ -126,24 +234,122 @@ func (sc *Component) runHttpProcess() <-chan struct{} {
 		port:     config.HttpPort(),
 		provider: provider,
 		rxSmsCh:  sc.rxSmsCh,
+		logger:   sc.logger,
+	}
+	if cfg, ok := config.(CanDebug); ok {
+		http.debug = cfg.Debug()
 	}
 	if cfg, ok := config.(CanHttpAuth); ok {
 		http.user = cfg.HttpUsername()
 		http.password = cfg.HttpPassword()
 	}
-	return http.run()
+	healthCh := http.run()
+	if cfg, ok := config.(CanOnHttpReady); ok {
+		cfg.OnHttpReady()
+	}
+	return healthCh
 }
 
 // runXmppProcess starts the XMPP process
 func (sc *Component) runXmppProcess() <-chan struct{} {
 	x := &xmppProcess{
-		host:   sc.config.XmppHost(),
-		port:   sc.config.XmppPort(),
-		name:   sc.config.ComponentName(),
-		secret: sc.config.SharedSecret(),
+		address: fmt.Sprintf("%s:%d", sc.config.XmppHost(), sc.config.XmppPort()),
+		name:    sc.config.ComponentName(),
+		secret:  sc.config.SharedSecret(),
 
-		gatewayTx: sc.txXmppCh,
-		gatewayRx: sc.rxXmppCh,
+		gatewayTx:         sc.txXmppCh,
+		gatewayTxPresence: sc.txMucPresenceCh,
+		smQueue:           sc.smQueue,
+		router:            sc.router,
+		logger:            sc.logger,
+	}
+	if cfg, ok := sc.config.(CanDebug); ok {
+		x.debug = cfg.Debug()
+	}
+	if cfg, ok := sc.config.(CanOnXmppConnect); ok {
+		x.onConnect = cfg.OnXmppConnect
+	}
+	if cfg, ok := sc.config.(CanOnXmppDisconnect); ok {
+		x.onDisconnect = cfg.OnXmppDisconnect
 	}
 	return x.run()
 }
+
+// runMucProcess starts the MUC process, which provisions and maintains
+// XEP-0045 rooms for SMS group threads.  Callers must only invoke this
+// when sc.config implements CanMucService.
+func (sc *Component) runMucProcess() <-chan struct{} {
+	cfg, ok := sc.config.(CanMucService)
+	if !ok {
+		panic("runMucProcess called but Config doesn't implement CanMucService")
+	}
+
+	provider, err := sc.config.SmsProvider()
+	if err != nil {
+		msg := fmt.Sprintf("Couldn't choose an SMS provider: %s", err)
+		panic(msg)
+	}
+
+	m := &mucProcess{
+		service: cfg.MucService(),
+		jid:     sc.ownerJid(),
+
+		gatewayTxMessage:  sc.txXmppCh,
+		gatewayTxPresence: sc.txMucPresenceCh,
+		router:            sc.router,
+		sendSms:           provider.Send,
+		logger:            sc.logger,
+
+		rooms: sc.mucRooms,
+	}
+	sc.setMuc(m)
+	return m.run()
+}
+
+// setMuc records the running MUC process, guarded by mucMu since it's
+// written from Main's supervisor goroutine but read from sms2xmpp on
+// the gateway goroutine.
+func (sc *Component) setMuc(m *mucProcess) {
+	sc.mucMu.Lock()
+	sc.muc = m
+	sc.mucMu.Unlock()
+}
+
+// getMuc returns the running MUC process, or nil if CanMucService isn't
+// implemented or the process hasn't started yet.
+func (sc *Component) getMuc() *mucProcess {
+	sc.mucMu.Lock()
+	defer sc.mucMu.Unlock()
+	return sc.muc
+}
+
+// smsPermanentlyFailed turns a permanently-failed (or retries-exhausted)
+// outbound SMS into an `<error/>` reply to whoever originally sent the
+// XMPP message that produced it, so the user finds out their text never
+// went anywhere instead of it vanishing silently.
+func (sc *Component) smsPermanentlyFailed(job outboundSmsJob, err error) {
+	sc.logger.Error("permanently failed to send SMS", "to", job.to, "error", err)
+	if job.origin == nil {
+		return
+	}
+	sc.txXmppCh <- &xco.Message{
+		Header: xco.Header{
+			To:   job.origin.From,
+			From: job.origin.To,
+		},
+		Type:    xco.ERROR,
+		Body:    job.origin.Body,
+		XMLName: job.origin.XMLName,
+	}
+}
+
+// smQueueEvicted is called whenever the stream management queue has to
+// drop a stanza that never got acked by the XMPP server.  We can't know
+// whether the server actually received it, and none of the messages
+// sms2xmpp or the MUC subsystem send carry an Id we could use to
+// correlate the loss back to a pending SMS receipt, so the best we can
+// do is make the loss visible with enough detail to follow up on
+// manually instead of it vanishing silently.
+func (sc *Component) smQueueEvicted(msg *xco.Message, err error) {
+	sc.logger.Warn(err.Error(), "to", msg.To.String(), "from", msg.From.String(), "body", msg.Body)
+}
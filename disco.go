@@ -0,0 +1,86 @@
+package sms // import "github.com/mndrix/sms-over-xmpp"
+
+import (
+	"encoding/xml"
+
+	xco "github.com/mndrix/go-xco"
+)
+
+// discoIdentity describes sms-over-xmpp to anyone who asks disco#info.
+// "gateway/sms" is the category/type pair the XMPP registrar defines
+// for exactly this kind of bridge.
+var discoIdentity = xco.DiscoIdentity{Category: "gateway", Type: "sms", Name: "SMS-over-XMPP gateway"}
+
+// discoFeatures lists the namespaces this component understands, beyond
+// disco#info itself -- go-xco's own DiscoInfoReply adds that one for us.
+var discoFeatures = []xco.DiscoFeature{
+	{Var: nsDiscoItems},
+	{Var: nsPing},
+	{Var: nsReceipts},
+	{Var: "urn:xmpp:sm:3"},
+}
+
+// discoInfoReply answers a disco#info query with the identity above and
+// the namespaces this component understands.  It only makes sense to
+// call this for an iq where iq.IsDiscoInfo() is true.
+func discoInfoReply(iq *xco.Iq) *xco.Iq {
+	features := append([]xco.DiscoFeature(nil), discoFeatures...)
+	reply, _ := iq.DiscoInfoReply([]xco.DiscoIdentity{discoIdentity}, features)
+	return reply
+}
+
+// iqReply is the envelope disco#items and ping replies are built from.
+// go-xco's own Iq type only carries the disco#info and vCard payloads it
+// knows how to decode; anything else gets its own minimal element
+// instead of trying to wedge it into Iq's typed fields.
+type iqReply struct {
+	XMLName xml.Name `xml:"iq"`
+	Id      string   `xml:"id,attr"`
+	To      string   `xml:"to,attr"`
+	From    string   `xml:"from,attr"`
+	Type    string   `xml:"type,attr"`
+	Payload interface{}
+}
+
+func newIqReply(iq *xco.Iq, payload interface{}) *iqReply {
+	return &iqReply{
+		Id:      iq.ID,
+		To:      iq.From.String(),
+		From:    iq.To.String(),
+		Type:    "result",
+		Payload: payload,
+	}
+}
+
+// discoItemsReply answers a disco#items query.  The component doesn't
+// expose any child items of its own, so it replies with an empty list
+// rather than ignoring the request.
+func discoItemsReply(iq *xco.Iq) *iqReply {
+	type query struct {
+		XMLName xml.Name `xml:"http://jabber.org/protocol/disco#items query"`
+	}
+	return newIqReply(iq, query{})
+}
+
+// pingReply answers a XEP-0199 ping with an empty result, which is all
+// the protocol requires.
+func pingReply(iq *xco.Iq) *iqReply {
+	return newIqReply(iq, nil)
+}
+
+// receiptReply builds the `<received/>` acknowledging a XEP-0184
+// receipt request carried on msg.  XMLName is copied from msg, the same
+// way go-xco's own Message.Response does it, so the reply keeps
+// whatever element name/namespace the original request arrived under.
+func receiptReply(msg *xco.Message) *xco.Message {
+	return &xco.Message{
+		Header: xco.Header{
+			ID:   msg.ID,
+			To:   msg.From,
+			From: msg.To,
+		},
+		Type:       msg.Type,
+		ReceiptAck: &xco.ReceiptAck{Id: msg.ID},
+		XMLName:    msg.XMLName,
+	}
+}
@@ -0,0 +1,96 @@
+package sms // import "github.com/mndrix/sms-over-xmpp"
+
+import (
+	"encoding/xml"
+	"strings"
+
+	xco "github.com/mndrix/go-xco"
+	"github.com/pkg/errors"
+)
+
+// CanOwnerJid is an optional Config interface.  When implemented, it
+// names the single human XMPP user this component bridges SMS for --
+// the JID that receives 1:1 SMS-turned-chat messages and gets joined
+// into every MUC room a group thread provisions.
+type CanOwnerJid interface {
+	OwnerJid() string
+}
+
+// ownerJid returns the local user's JID.  Config must implement
+// CanOwnerJid; there's no sane default for "whose phone is this."
+func (sc *Component) ownerJid() string {
+	cfg, ok := sc.config.(CanOwnerJid)
+	if !ok {
+		panic("Config must implement CanOwnerJid")
+	}
+	return cfg.OwnerJid()
+}
+
+// phoneFromJid extracts the destination phone number (or short code)
+// from the local part of a gateway-addressed JID, e.g.
+// "+15551234@sms.example.com" -> "+15551234".
+func phoneFromJid(jid string) string {
+	return strings.SplitN(jid, "@", 2)[0]
+}
+
+// sms2xmpp delivers an inbound SMS into XMPP: a group thread goes to the
+// MUC room provisioned for its participants, everything else goes
+// straight to the owner as a 1:1 chat message.
+func (sc *Component) sms2xmpp(s sms) error {
+	if len(s.Participants) > 1 {
+		muc := sc.getMuc()
+		if muc == nil {
+			return errors.New("received a group SMS but no MUC process is running")
+		}
+		muc.postSms(s.From, s.Participants, s.Body)
+		return nil
+	}
+
+	sc.txXmppCh <- &xco.Message{
+		Header: xco.Header{
+			To:   parseAddress(sc.logger, sc.ownerJid()),
+			From: parseAddress(sc.logger, s.From),
+		},
+		Type:    xco.CHAT,
+		Body:    s.Body,
+		XMLName: xml.Name{Local: "message"},
+	}
+	return nil
+}
+
+// xmpp2sms delivers an outbound chat message from the owner to its SMS
+// destination, the local part of the JID they addressed it to.
+func (sc *Component) xmpp2sms(msg *xco.Message) error {
+	if msg.Body == "" {
+		return ErrIgnoreMessage
+	}
+
+	to := phoneFromJid(msg.To.String())
+	if sc.outboundSms != nil {
+		sc.outboundSms.Enqueue(to, msg.Body, msg)
+		return nil
+	}
+
+	provider, err := sc.config.SmsProvider()
+	if err != nil {
+		return err
+	}
+	return provider.Send(to, msg.Body)
+}
+
+// smsDelivered turns a provider's delivery receipt for id into an XMPP
+// receipt ack, if we're still holding one for it.
+func (sc *Component) smsDelivered(id string) error {
+	sc.receiptForMutex.Lock()
+	receipt, ok := sc.receiptFor[id]
+	if ok {
+		delete(sc.receiptFor, id)
+	}
+	sc.receiptForMutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+	sc.txXmppCh <- receipt
+	return nil
+}
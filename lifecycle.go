@@ -0,0 +1,30 @@
+package sms // import "github.com/mndrix/sms-over-xmpp"
+
+// CanOnXmppConnect is an optional Config interface.  When implemented,
+// OnXmppConnect is called every time xmppProcess establishes (or
+// resumes) a session, with a Sender the callback can use to push
+// initial presence, roster requests, or disco caps -- the same
+// post-connect hook pattern most modern XMPP libraries provide.
+type CanOnXmppConnect interface {
+	OnXmppConnect(Sender)
+}
+
+// CanOnXmppDisconnect is an optional Config interface.  When
+// implemented, OnXmppDisconnect is called with the error that brought
+// the XMPP session down (nil for a clean shutdown).
+type CanOnXmppDisconnect interface {
+	OnXmppDisconnect(error)
+}
+
+// CanOnHttpReady is an optional Config interface.  When implemented,
+// OnHttpReady is called once httpProcess is listening.
+type CanOnHttpReady interface {
+	OnHttpReady()
+}
+
+// CanOnGatewayReady is an optional Config interface.  When implemented,
+// OnGatewayReady is called once the gateway process is servicing
+// rxSmsCh/rxXmppCh.
+type CanOnGatewayReady interface {
+	OnGatewayReady()
+}
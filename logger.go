@@ -0,0 +1,106 @@
+package sms // import "github.com/mndrix/sms-over-xmpp"
+
+import (
+	"fmt"
+	"log"
+)
+
+// Logger is how every process on Component reports what it's doing.
+// Implement it to route sms-over-xmpp's logs into your own logging
+// system (zap, zerolog, journald, ...) instead of the stdlib default.
+// Fields are passed as alternating key, value pairs, the same
+// convention go-kit/log and zap's SugaredLogger use.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// NewStdLogger returns a Logger that writes to the stdlib "log"
+// package, which is what Component used unconditionally before Logger
+// existed.  It's the default when a Config doesn't implement
+// CanLogger.
+func NewStdLogger() Logger {
+	return stdLogger{}
+}
+
+// Discard is a Logger that throws every message away.  It's useful for
+// embedders who want to silence the noisy per-reconnect messages
+// entirely rather than routing them somewhere.
+var Discard Logger = discardLogger{}
+
+type stdLogger struct{}
+
+func (stdLogger) Debug(msg string, keyvals ...interface{}) { stdLog("DEBUG", msg, keyvals) }
+func (stdLogger) Info(msg string, keyvals ...interface{})  { stdLog("INFO", msg, keyvals) }
+func (stdLogger) Warn(msg string, keyvals ...interface{})  { stdLog("WARN", msg, keyvals) }
+func (stdLogger) Error(msg string, keyvals ...interface{}) { stdLog("ERROR", msg, keyvals) }
+
+func stdLog(level, msg string, keyvals []interface{}) {
+	if len(keyvals) == 0 {
+		log.Printf("%s: %s", level, msg)
+		return
+	}
+	log.Printf("%s: %s %s", level, msg, formatKeyvals(keyvals))
+}
+
+// formatKeyvals renders keyvals as "k1=v1 k2=v2 ...".  An odd trailing
+// key with no value gets "(MISSING)" rather than panicking, since a
+// malformed log call shouldn't take down whichever process made it.
+func formatKeyvals(keyvals []interface{}) string {
+	out := make([]byte, 0, 16*len(keyvals))
+	for i := 0; i < len(keyvals); i += 2 {
+		if i > 0 {
+			out = append(out, ' ')
+		}
+		out = append(out, []byte(fmtValue(keyvals[i]))...)
+		out = append(out, '=')
+		if i+1 < len(keyvals) {
+			out = append(out, []byte(fmtValue(keyvals[i+1]))...)
+		} else {
+			out = append(out, []byte("(MISSING)")...)
+		}
+	}
+	return string(out)
+}
+
+func fmtValue(v interface{}) string {
+	if err, ok := v.(error); ok {
+		return err.Error()
+	}
+	return toString(v)
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Debug(msg string, keyvals ...interface{}) {}
+func (discardLogger) Info(msg string, keyvals ...interface{})  {}
+func (discardLogger) Warn(msg string, keyvals ...interface{})  {}
+func (discardLogger) Error(msg string, keyvals ...interface{}) {}
+
+// CanLogger is an optional Config interface.  A Config implementing it
+// supplies the Logger every process on Component reports through; a
+// Config that doesn't gets the stdlib-backed default from
+// NewStdLogger.
+type CanLogger interface {
+	Logger() Logger
+}
+
+// CanDebug is an optional Config interface.  When it reports true,
+// httpProcess and xmppProcess trace raw wire-level HTTP/XML to the
+// Logger at Debug level, which is far too noisy to want on by default.
+type CanDebug interface {
+	Debug() bool
+}
+
+func toString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case fmt.Stringer:
+		return x.String()
+	default:
+		return fmt.Sprintf("%v", x)
+	}
+}
@@ -0,0 +1,254 @@
+package sms // import "github.com/mndrix/sms-over-xmpp"
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"sort"
+	"strings"
+	"sync"
+
+	xco "github.com/mndrix/go-xco"
+)
+
+// CanMucService is an optional Config interface.  A Config implementing
+// it opts into the MUC subsystem for SMS group threads; the returned
+// string is the MUC component (e.g. "conference.example.com") group
+// rooms get provisioned on.  Embedders who never send or receive group
+// SMS don't need to implement it -- Main simply never starts the MUC
+// process.
+type CanMucService interface {
+	MucService() string
+}
+
+// mucRoom tracks the XMPP side of one SMS group thread: the room it
+// was provisioned at, and the nick we've assigned each participant's
+// occupant JID.
+type mucRoom struct {
+	// jid is the bare JID of the provisioned room, e.g.
+	// "a1b2c3@conference.example.com".
+	jid string
+
+	// nicks maps an SMS participant address (whatever the provider
+	// uses to identify a sender, usually an E.164 number) to the
+	// occupant nick we registered them under in the room.
+	nicks map[string]string
+}
+
+// mucRoomStore is the room-persistence half of the MUC subsystem: the
+// participant-set -> room and participant -> occupant-nick mappings.
+// It lives on Component, like smQueue, so it survives a mucProcess
+// restart -- the same set of SMS participants must always land back in
+// the same room.
+type mucRoomStore struct {
+	mu    sync.Mutex
+	rooms map[string]*mucRoom // keyed by participantsKey
+}
+
+func newMucRoomStore() *mucRoomStore {
+	return &mucRoomStore{rooms: make(map[string]*mucRoom)}
+}
+
+// mucProcess is the third peer to httpProcess/xmppProcess: it owns
+// provisioning MUC rooms for SMS group threads and the XEP-0045
+// presence bookkeeping that goes with them.
+type mucProcess struct {
+	// service is the MUC component SMS group rooms are provisioned on,
+	// e.g. "conference.example.com".
+	service string
+
+	// jid is the local user's JID; they're joined into every room this
+	// process provisions.
+	jid string
+
+	gatewayTxMessage  chan<- *xco.Message
+	gatewayTxPresence chan<- interface{}
+	router            *Router
+
+	// sendSms hands body to the SMS provider for delivery to to.  It's
+	// injected rather than held as a provider reference directly so
+	// mucProcess doesn't need to duplicate the provider selection logic
+	// that already lives in runHttpProcess.
+	sendSms func(to, body string) error
+
+	rooms *mucRoomStore
+
+	// logger is where mucProcess reports things it can't act on itself,
+	// like a failed outbound SMS send from handleGroupchat's fan-out.
+	logger Logger
+}
+
+// run registers the MUC stanza handlers on the shared router.
+// mucProcess has no listener of its own -- it rides xmppProcess's
+// connection -- so "running" just means "handlers registered".  Nothing
+// closes the returned channel, so Main never sees this process die.
+func (m *mucProcess) run() <-chan struct{} {
+	m.router.HandleFunc("message", "", "groupchat", m.handleGroupchat)
+	// go-xco's Presence has no generic payload field, so there's no way
+	// to route on the muc#user namespace the way Dispatch routes iq and
+	// message by their payload's namespace; "" is the only route a
+	// presence stanza can ever match, so that's what handlePresence has
+	// to register under.  It ignores anything that isn't one of its own
+	// rooms going unavailable.
+	m.router.HandleFunc("presence", "", "", m.handlePresence)
+	return make(chan struct{})
+}
+
+// participantsKey derives a stable room-lookup key from a set of SMS
+// participant addresses, independent of the order the provider reports
+// them in, so the same set of people always reuses the same room.
+func participantsKey(participants []string) string {
+	sorted := append([]string(nil), participants...)
+	sort.Strings(sorted)
+	sum := sha1.Sum([]byte(strings.Join(sorted, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// roomFor returns the room provisioned for participants, provisioning
+// (and joining the local user into) a new one if this exact
+// participant set hasn't been seen before.
+func (m *mucProcess) roomFor(participants []string) *mucRoom {
+	key := participantsKey(participants)
+
+	m.rooms.mu.Lock()
+	defer m.rooms.mu.Unlock()
+
+	if room, ok := m.rooms.rooms[key]; ok {
+		return room
+	}
+
+	room := &mucRoom{
+		jid:   key + "@" + m.service,
+		nicks: make(map[string]string),
+	}
+	for _, p := range participants {
+		room.nicks[p] = occupantNick(p)
+	}
+	m.rooms.rooms[key] = room
+	m.joinRoom(room)
+	return room
+}
+
+// occupantNick turns a raw SMS participant address into something
+// presentable as a MUC nick.  It's deliberately simple: the provider
+// address itself, since XMPP nicks tolerate most characters that show
+// up in a phone number or short code.
+func occupantNick(participant string) string {
+	return participant
+}
+
+// mucJoinPresence is the join presence XEP-0045 requires: a bare
+// <presence/> carrying a `http://jabber.org/protocol/muc` namespaced
+// <x/> child.  go-xco's own Presence type has no generic payload field
+// to attach one, so this is its own minimal envelope rather than an
+// xco.Presence.
+type mucJoinPresence struct {
+	XMLName xml.Name `xml:"presence"`
+	To      string   `xml:"to,attr"`
+	From    string   `xml:"from,attr"`
+	X       struct {
+		XMLName xml.Name `xml:"http://jabber.org/protocol/muc x"`
+	}
+}
+
+// joinRoom sends the local user's own join presence to a freshly
+// provisioned room.
+func (m *mucProcess) joinRoom(room *mucRoom) {
+	m.gatewayTxPresence <- &mucJoinPresence{
+		To:   room.jid + "/" + m.jid,
+		From: m.jid,
+	}
+}
+
+// postSms delivers an inbound SMS group message into the room for
+// participants, as a groupchat message from the sending participant's
+// occupant JID.
+func (m *mucProcess) postSms(from string, participants []string, body string) {
+	room := m.roomFor(participants)
+
+	m.rooms.mu.Lock()
+	nick, ok := room.nicks[from]
+	if !ok {
+		nick = occupantNick(from)
+		room.nicks[from] = nick
+	}
+	m.rooms.mu.Unlock()
+
+	m.gatewayTxMessage <- &xco.Message{
+		Header: xco.Header{
+			To:   parseAddress(m.logger, m.jid),
+			From: parseAddress(m.logger, room.jid+"/"+nick),
+		},
+		Type:    xco.GROUPCHAT,
+		Body:    body,
+		XMLName: xml.Name{Local: "message"},
+	}
+}
+
+// handleGroupchat fans an outbound groupchat message from the local
+// user out to every other participant in the room via the SMS
+// provider.
+func (m *mucProcess) handleGroupchat(sender Sender, stanza interface{}) error {
+	msg, ok := stanza.(*xco.Message)
+	if !ok || msg.Body == "" {
+		return nil
+	}
+
+	roomJid := strings.SplitN(msg.To.String(), "/", 2)[0]
+
+	m.rooms.mu.Lock()
+	var room *mucRoom
+	for _, r := range m.rooms.rooms {
+		if r.jid == roomJid {
+			room = r
+			break
+		}
+	}
+	var recipients []string
+	if room != nil {
+		for participant := range room.nicks {
+			recipients = append(recipients, participant)
+		}
+	}
+	m.rooms.mu.Unlock()
+
+	if room == nil {
+		return nil // not one of ours
+	}
+
+	for _, participant := range recipients {
+		go func(to string) {
+			if err := m.sendSms(to, msg.Body); err != nil {
+				m.logger.Error("sending group SMS", "to", to, "error", err)
+			}
+		}(participant)
+	}
+	return nil
+}
+
+// handlePresence drops a participant from room.nicks when their occupant
+// JID in one of this process's rooms goes unavailable.  Available
+// presence and affiliation changes are not tracked.
+func (m *mucProcess) handlePresence(sender Sender, stanza interface{}) error {
+	pres, ok := stanza.(*xco.Presence)
+	if !ok || pres.Type != "unavailable" {
+		return nil
+	}
+
+	roomJid := strings.SplitN(pres.From.String(), "/", 2)[0]
+
+	m.rooms.mu.Lock()
+	defer m.rooms.mu.Unlock()
+	for _, room := range m.rooms.rooms {
+		if room.jid != roomJid {
+			continue
+		}
+		for participant, nick := range room.nicks {
+			if roomJid+"/"+nick == pres.From.String() {
+				delete(room.nicks, participant)
+			}
+		}
+		break
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package sms
+
+import (
+	"sync"
+	"testing"
+
+	xco "github.com/mndrix/go-xco"
+)
+
+func newTestMucProcess() (*mucProcess, chan *xco.Message, chan interface{}) {
+	msgCh := make(chan *xco.Message, 8)
+	presCh := make(chan interface{}, 8)
+	return &mucProcess{
+		service: "conference.example.com",
+		jid:     "owner@example.com",
+
+		gatewayTxMessage:  msgCh,
+		gatewayTxPresence: presCh,
+		router:            NewRouter(),
+		sendSms:           func(to, body string) error { return nil },
+		logger:            NewStdLogger(),
+
+		rooms: newMucRoomStore(),
+	}, msgCh, presCh
+}
+
+func TestMucProcessRoomForReusesRoomForSameParticipants(t *testing.T) {
+	m, _, presCh := newTestMucProcess()
+
+	r1 := m.roomFor([]string{"+1555", "+1777"})
+	r2 := m.roomFor([]string{"+1777", "+1555"}) // same set, different order
+
+	if r1 != r2 {
+		t.Fatal("roomFor provisioned a second room for the same participant set")
+	}
+	select {
+	case <-presCh:
+	default:
+		t.Fatal("roomFor didn't join the local user into the freshly provisioned room")
+	}
+	select {
+	case <-presCh:
+		t.Fatal("roomFor joined twice for what should be a single provisioning")
+	default:
+	}
+}
+
+func TestMucProcessRoomForDifferentParticipantsGetDifferentRooms(t *testing.T) {
+	m, _, _ := newTestMucProcess()
+
+	r1 := m.roomFor([]string{"+1555"})
+	r2 := m.roomFor([]string{"+1888"})
+
+	if r1.jid == r2.jid {
+		t.Fatal("different participant sets were provisioned into the same room")
+	}
+}
+
+func TestMucProcessPostSmsSendsGroupchatFromSenderNick(t *testing.T) {
+	m, msgCh, _ := newTestMucProcess()
+
+	m.postSms("+1555", []string{"+1555", "+1777"}, "hi there")
+
+	select {
+	case msg := <-msgCh:
+		if msg.Type != "groupchat" || msg.Body != "hi there" {
+			t.Fatalf("got %#v, want a groupchat message with the SMS body", msg)
+		}
+		room := m.roomFor([]string{"+1555", "+1777"})
+		if want := room.jid + "/" + occupantNick("+1555"); msg.From.String() != want {
+			t.Fatalf("got from %q, want %q", msg.From.String(), want)
+		}
+	default:
+		t.Fatal("postSms didn't send a groupchat message")
+	}
+}
+
+func TestMucProcessHandleGroupchatFansOutToOtherParticipants(t *testing.T) {
+	m, _, _ := newTestMucProcess()
+	room := m.roomFor([]string{"+1555", "+1777"})
+
+	var mu sync.Mutex
+	var sent []string
+	done := make(chan struct{}, 2)
+	m.sendSms = func(to, body string) error {
+		mu.Lock()
+		sent = append(sent, to)
+		mu.Unlock()
+		done <- struct{}{}
+		return nil
+	}
+
+	msg := &xco.Message{Header: xco.Header{To: mustAddr(room.jid + "/owner")}, Type: "groupchat", Body: "hello room"}
+	if err := m.handleGroupchat(&fakeSender{}, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	<-done
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != 2 {
+		t.Fatalf("got %d sends, want one per participant", len(sent))
+	}
+}
+
+func TestMucProcessHandleGroupchatIgnoresUnknownRoom(t *testing.T) {
+	m, _, _ := newTestMucProcess()
+
+	msg := &xco.Message{Header: xco.Header{To: mustAddr("nobody@conference.example.com/owner")}, Type: "groupchat", Body: "hello"}
+	if err := m.handleGroupchat(&fakeSender{}, msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMucProcessHandlePresenceRemovesParticipantOnUnavailable(t *testing.T) {
+	m, _, _ := newTestMucProcess()
+	room := m.roomFor([]string{"+1555", "+1777"})
+
+	pres := &xco.Presence{Header: xco.Header{From: mustAddr(room.jid + "/" + occupantNick("+1555"))}, Type: "unavailable"}
+	if err := m.handlePresence(&fakeSender{}, pres); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := room.nicks["+1555"]; ok {
+		t.Fatal("handlePresence didn't remove the participant that went unavailable")
+	}
+	if _, ok := room.nicks["+1777"]; !ok {
+		t.Fatal("handlePresence removed a participant that's still present")
+	}
+}
@@ -0,0 +1,196 @@
+package sms // import "github.com/mndrix/sms-over-xmpp"
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	xco "github.com/mndrix/go-xco"
+)
+
+// SmsOutcome classifies how a provider responded to an outbound SMS
+// send attempt.
+type SmsOutcome int
+
+const (
+	// SmsAck means the provider accepted the message for delivery.
+	SmsAck SmsOutcome = iota
+
+	// SmsNackRetryable means the provider rejected the message but the
+	// same failure might not recur, e.g. a rate limit or a transient
+	// carrier error.
+	SmsNackRetryable
+
+	// SmsNackPermanent means the provider rejected the message in a way
+	// retrying won't fix, e.g. an invalid destination number.
+	SmsNackPermanent
+
+	// SmsReceipt means the provider is reporting a delivery receipt for
+	// a previously accepted message, rather than responding to a new
+	// send.
+	SmsReceipt
+)
+
+// RetryableProvider is an optional SmsProvider interface.  A provider
+// implementing it gets automatic retry-with-backoff handling from
+// Component's outbound SMS queue instead of a single fire-and-forget
+// send.
+type RetryableProvider interface {
+	// SendRetryable sends body to to and reports the provider's own SMS
+	// id for the attempt alongside how the attempt should be classified.
+	SendRetryable(to, body string) (id string, outcome SmsOutcome, err error)
+}
+
+const (
+	outboundSmsMin        = 1 * time.Second
+	outboundSmsMax        = 10 * time.Minute
+	outboundSmsMaxRetries = 8
+)
+
+// outboundSmsJob is one SMS the gateway wants delivered, plus the
+// context needed to retry it and to synthesize an XMPP error back to
+// whoever sent it if every retry is exhausted.
+type outboundSmsJob struct {
+	to, body string
+
+	// origin is the XMPP message that produced this SMS, kept so a
+	// permanent failure can be turned into an `<error/>` reply to the
+	// original sender.
+	origin *xco.Message
+
+	attempt int
+}
+
+// outboundSmsQueue is a buffered work queue plus worker pool for
+// outbound SMS sends.  It classifies provider responses into
+// ack/nack-retryable/nack-permanent/receipt, retries retryable
+// failures with full-jitter exponential backoff per destination (at most
+// outboundSmsMaxRetries sends in all, the first one included), and on
+// permanent failure reports the failure
+// back to the original XMPP sender.
+type outboundSmsQueue struct {
+	jobs     chan outboundSmsJob
+	provider RetryableProvider
+
+	// onPermanentFailure is called with the job and the error that
+	// killed it, once retries are exhausted or the provider reports a
+	// permanent nack.  Component uses it to synthesize the `<error/>`
+	// reply.
+	onPermanentFailure func(outboundSmsJob, error)
+
+	logger Logger
+
+	backoffs      map[string]*destBackoff
+	backoffsMutex sync.Mutex
+
+	depth    int32 // jobs currently queued or retrying
+	inflight int32 // jobs currently in a provider call
+}
+
+// newOutboundSmsQueue creates a queue with workers concurrent workers
+// and a buffer of the same size -- enough that a burst of outbound
+// messages doesn't immediately block the gateway loop that feeds it.
+func newOutboundSmsQueue(provider RetryableProvider, logger Logger, workers int) *outboundSmsQueue {
+	if workers <= 0 {
+		workers = 4
+	}
+	q := &outboundSmsQueue{
+		jobs:     make(chan outboundSmsJob, workers),
+		provider: provider,
+		logger:   logger,
+		backoffs: make(map[string]*destBackoff),
+	}
+	for i := 0; i < workers; i++ {
+		go q.worker()
+	}
+	return q
+}
+
+// Depth reports how many jobs are currently queued or awaiting a
+// backoff-delayed retry.
+func (q *outboundSmsQueue) Depth() int {
+	return int(atomic.LoadInt32(&q.depth))
+}
+
+// Inflight reports how many jobs are currently inside a provider call.
+func (q *outboundSmsQueue) Inflight() int {
+	return int(atomic.LoadInt32(&q.inflight))
+}
+
+// Enqueue submits body for delivery to to, on behalf of the XMPP
+// message origin (used only if every retry fails).
+func (q *outboundSmsQueue) Enqueue(to, body string, origin *xco.Message) {
+	atomic.AddInt32(&q.depth, 1)
+	q.jobs <- outboundSmsJob{to: to, body: body, origin: origin}
+}
+
+func (q *outboundSmsQueue) worker() {
+	for job := range q.jobs {
+		q.attempt(job)
+	}
+}
+
+func (q *outboundSmsQueue) attempt(job outboundSmsJob) {
+	atomic.AddInt32(&q.inflight, 1)
+	_, outcome, err := q.provider.SendRetryable(job.to, job.body)
+	atomic.AddInt32(&q.inflight, -1)
+
+	switch outcome {
+	case SmsAck, SmsReceipt:
+		atomic.AddInt32(&q.depth, -1)
+		q.backoffReset(job.to)
+	case SmsNackPermanent:
+		atomic.AddInt32(&q.depth, -1)
+		q.backoffReset(job.to)
+		if q.onPermanentFailure != nil {
+			q.onPermanentFailure(job, err)
+		}
+	case SmsNackRetryable:
+		job.attempt++
+		if job.attempt >= outboundSmsMaxRetries {
+			atomic.AddInt32(&q.depth, -1)
+			q.backoffReset(job.to)
+			if q.onPermanentFailure != nil {
+				q.onPermanentFailure(job, err)
+			}
+			return
+		}
+		delay := q.backoffFor(job.to).next(time.Now())
+		q.logger.Warn("retrying outbound SMS", "to", job.to, "attempt", job.attempt, "delay", delay.String())
+		time.AfterFunc(delay, func() { q.jobs <- job })
+	}
+}
+
+func (q *outboundSmsQueue) backoffFor(to string) *destBackoff {
+	q.backoffsMutex.Lock()
+	defer q.backoffsMutex.Unlock()
+	b, ok := q.backoffs[to]
+	if !ok {
+		b = &destBackoff{b: backoff{Min: outboundSmsMin, Max: outboundSmsMax}}
+		q.backoffs[to] = b
+	}
+	return b
+}
+
+func (q *outboundSmsQueue) backoffReset(to string) {
+	q.backoffsMutex.Lock()
+	defer q.backoffsMutex.Unlock()
+	delete(q.backoffs, to)
+}
+
+// destBackoff guards a single destination's backoff with its own lock.
+// backoff itself isn't safe for concurrent use, and a destination's
+// backoff is shared across every worker retrying a send to it, so the
+// lock that protects the backoffs map lookup isn't enough -- two workers
+// retrying the same destination at once would otherwise race on the
+// wrapped backoff's state.
+type destBackoff struct {
+	mu sync.Mutex
+	b  backoff
+}
+
+func (d *destBackoff) next(started time.Time) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.b.next(started)
+}
@@ -0,0 +1,109 @@
+package sms
+
+import (
+	"sync"
+	"testing"
+
+	xco "github.com/mndrix/go-xco"
+)
+
+type fakeRetryProvider struct {
+	outcome SmsOutcome
+	err     error
+	calls   int
+}
+
+func (f *fakeRetryProvider) SendRetryable(to, body string) (string, SmsOutcome, error) {
+	f.calls++
+	return "id", f.outcome, f.err
+}
+
+func newTestOutboundSmsQueue(provider RetryableProvider) *outboundSmsQueue {
+	return newOutboundSmsQueue(provider, NewStdLogger(), 1)
+}
+
+func TestOutboundSmsQueueBackoffForReusesPerDestination(t *testing.T) {
+	q := newTestOutboundSmsQueue(&fakeRetryProvider{})
+
+	a1 := q.backoffFor("+1555")
+	a2 := q.backoffFor("+1555")
+	b1 := q.backoffFor("+1777")
+
+	if a1 != a2 {
+		t.Fatal("backoffFor returned a different instance for the same destination")
+	}
+	if a1 == b1 {
+		t.Fatal("backoffFor returned the same instance for different destinations")
+	}
+}
+
+func TestOutboundSmsQueueBackoffResetStartsFresh(t *testing.T) {
+	q := newTestOutboundSmsQueue(&fakeRetryProvider{})
+
+	before := q.backoffFor("+1555")
+	q.backoffReset("+1555")
+	after := q.backoffFor("+1555")
+
+	if before == after {
+		t.Fatal("backoffFor returned the same instance after backoffReset")
+	}
+}
+
+func TestOutboundSmsQueueAttemptAckClearsBackoff(t *testing.T) {
+	provider := &fakeRetryProvider{outcome: SmsAck}
+	q := newTestOutboundSmsQueue(provider)
+
+	before := q.backoffFor("+1555")
+	q.attempt(outboundSmsJob{to: "+1555", body: "hi"})
+	after := q.backoffFor("+1555")
+
+	if before == after {
+		t.Fatal("a successful attempt should reset the destination's backoff")
+	}
+	if q.Depth() != -1 {
+		t.Fatalf("got depth %d, want -1 (attempt didn't go through Enqueue)", q.Depth())
+	}
+}
+
+func TestOutboundSmsQueueAttemptPermanentNackReportsFailure(t *testing.T) {
+	provider := &fakeRetryProvider{outcome: SmsNackPermanent}
+	q := newTestOutboundSmsQueue(provider)
+
+	var reported outboundSmsJob
+	var mu sync.Mutex
+	q.onPermanentFailure = func(job outboundSmsJob, err error) {
+		mu.Lock()
+		reported = job
+		mu.Unlock()
+	}
+
+	origin := &xco.Message{Header: xco.Header{From: mustAddr("owner@example.com"), To: mustAddr("+1555@sms.example.com")}}
+	q.attempt(outboundSmsJob{to: "+1555", body: "hi", origin: origin})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if reported.origin != origin {
+		t.Fatal("onPermanentFailure wasn't called with the failing job")
+	}
+}
+
+func TestOutboundSmsQueueAttemptExhaustedRetriesReportsFailure(t *testing.T) {
+	provider := &fakeRetryProvider{outcome: SmsNackRetryable}
+	q := newTestOutboundSmsQueue(provider)
+
+	var called bool
+	var mu sync.Mutex
+	q.onPermanentFailure = func(job outboundSmsJob, err error) {
+		mu.Lock()
+		called = true
+		mu.Unlock()
+	}
+
+	q.attempt(outboundSmsJob{to: "+1555", body: "hi", attempt: outboundSmsMaxRetries})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !called {
+		t.Fatal("exhausting every retry should report a permanent failure")
+	}
+}
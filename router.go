@@ -0,0 +1,184 @@
+package sms // import "github.com/mndrix/sms-over-xmpp"
+
+import (
+	"sync"
+
+	xco "github.com/mndrix/go-xco"
+)
+
+// Sender is the connection surface a stanza handler needs to reply: push
+// a stanza back out over the live XMPP connection.  It's satisfied by
+// *xco.Component itself, so handlers never have to know whether they're
+// running against the real connection or a test fake.
+type Sender interface {
+	Send(interface{}) error
+}
+
+// StanzaHandlerFunc handles a single inbound stanza.  sender can be used
+// to push a reply or any other stanza back to the XMPP server.  A
+// returned error is logged by the router; it does not stop dispatch of
+// later stanzas.
+type StanzaHandlerFunc func(sender Sender, stanza interface{}) error
+
+// CanRouter is an optional Config interface.  A Config implementing it
+// can supply its own Router -- pre-populated with application-specific
+// handlers -- instead of getting the default, builtins-only Router
+// Main would otherwise construct.
+type CanRouter interface {
+	Router() *Router
+}
+
+// route identifies the stanzas a handler is interested in: the stanza
+// name ("iq", "message", "presence", ...), the namespace of its payload
+// (e.g. "http://jabber.org/protocol/disco#info"), and its type attribute
+// (e.g. "get" for iq, "groupchat" for message).  An empty namespace or
+// type only acts as a wildcard in the fallbacks lookup tries.
+type route struct {
+	name, namespace, typ string
+}
+
+// Router dispatches inbound XMPP stanzas to registered handlers by
+// stanza name, payload namespace and type.  It's installed on
+// Component at construction time so that callers of sms.Main can add
+// their own handlers -- for service discovery, vCards, ad-hoc commands,
+// PubSub, or anything else -- without forking xmppProcess.
+type Router struct {
+	mu       sync.RWMutex
+	handlers map[route]StanzaHandlerFunc
+
+	// logger is where a handler's returned error gets logged, per
+	// StanzaHandlerFunc's contract.  It defaults to NewStdLogger so a
+	// Router built without Main (e.g. directly by a CanRouter Config)
+	// still reports handler errors somewhere; Main overrides it with
+	// Component's own logger via SetLogger.
+	logger Logger
+}
+
+// NewRouter creates a Router with only its built-in handlers
+// registered: disco#info, disco#items, XEP-0199 ping, and XEP-0184
+// delivery receipt requests.
+func NewRouter() *Router {
+	r := &Router{handlers: make(map[route]StanzaHandlerFunc), logger: NewStdLogger()}
+	r.registerBuiltins()
+	return r
+}
+
+// SetLogger replaces the logger Dispatch reports handler errors to.
+// Main calls this with Component's own logger so a CanRouter-supplied
+// Router logs errors the same way the rest of the component does.
+func (r *Router) SetLogger(logger Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logger = logger
+}
+
+// HandleFunc registers fn for stanzas matching name/namespace/typ.  A
+// later call with the same name/namespace/typ replaces the earlier
+// handler, which lets embedders override a built-in.
+func (r *Router) HandleFunc(name, namespace, typ string, fn StanzaHandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[route{name, namespace, typ}] = fn
+}
+
+// Dispatch routes stanza to the most specific matching handler and
+// invokes it.  It reports whether a handler was found.  xmppProcess
+// calls this for every stanza it reads, instead of only forwarding
+// `<message/>` over a private channel.
+func (r *Router) Dispatch(sender Sender, name, namespace, typ string, stanza interface{}) (bool, error) {
+	r.mu.RLock()
+	fn, logger := r.handlerAndLogger(name, namespace, typ)
+	r.mu.RUnlock()
+	if fn == nil {
+		return false, nil
+	}
+	err := fn(sender, stanza)
+	if err != nil {
+		logger.Error("stanza handler failed", "name", name, "namespace", namespace, "type", typ, "error", err)
+	}
+	return true, err
+}
+
+// handlerAndLogger looks up the handler for name/namespace/typ and
+// returns it alongside the router's current logger, in one locked
+// pass, so Dispatch doesn't need to re-acquire the lock just to log.
+func (r *Router) handlerAndLogger(name, namespace, typ string) (StanzaHandlerFunc, Logger) {
+	fn, ok := r.lookup(name, namespace, typ)
+	if !ok {
+		return nil, nil
+	}
+	return fn, r.logger
+}
+
+// lookup tries, in order of specificity, name+namespace+typ,
+// name+namespace, then name alone.
+func (r *Router) lookup(name, namespace, typ string) (StanzaHandlerFunc, bool) {
+	if fn, ok := r.handlers[route{name, namespace, typ}]; ok {
+		return fn, true
+	}
+	if fn, ok := r.handlers[route{name, namespace, ""}]; ok {
+		return fn, true
+	}
+	if fn, ok := r.handlers[route{name, "", ""}]; ok {
+		return fn, true
+	}
+	return nil, false
+}
+
+const (
+	nsDiscoInfo  = "http://jabber.org/protocol/disco#info"
+	nsDiscoItems = "http://jabber.org/protocol/disco#items"
+	nsPing       = "urn:xmpp:ping"
+	nsReceipts   = "urn:xmpp:receipts"
+)
+
+// registerBuiltins wires up the handlers every component needs just to
+// be a well-behaved XMPP entity, so embedders get them for free and
+// only need to add handlers for things specific to their deployment.
+func (r *Router) registerBuiltins() {
+	r.HandleFunc("iq", nsDiscoInfo, "get", handleDiscoInfo)
+	r.HandleFunc("iq", nsDiscoItems, "get", handleDiscoItems)
+	r.HandleFunc("iq", nsPing, "get", handlePing)
+	r.HandleFunc("message", nsReceipts, "", handleReceiptRequest)
+}
+
+func handleDiscoInfo(sender Sender, stanza interface{}) error {
+	iq, ok := stanza.(*xco.Iq)
+	if !ok {
+		return nil
+	}
+	reply := discoInfoReply(iq)
+	if reply == nil {
+		return nil
+	}
+	return sender.Send(reply)
+}
+
+func handleDiscoItems(sender Sender, stanza interface{}) error {
+	iq, ok := stanza.(*xco.Iq)
+	if !ok {
+		return nil
+	}
+	return sender.Send(discoItemsReply(iq))
+}
+
+func handlePing(sender Sender, stanza interface{}) error {
+	iq, ok := stanza.(*xco.Iq)
+	if !ok {
+		return nil
+	}
+	return sender.Send(pingReply(iq))
+}
+
+// handleReceiptRequest acknowledges a XEP-0184 receipt request
+// (`<request xmlns='urn:xmpp:receipts'/>`) with a matching `<received/>`.
+// Actual delivery tracking of the SMS itself is handled by the gateway;
+// this only satisfies the XMPP-level contract that every request gets a
+// receipt.
+func handleReceiptRequest(sender Sender, stanza interface{}) error {
+	msg, ok := stanza.(*xco.Message)
+	if !ok {
+		return nil
+	}
+	return sender.Send(receiptReply(msg))
+}
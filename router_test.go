@@ -0,0 +1,125 @@
+package sms
+
+import (
+	"errors"
+	"testing"
+
+	xco "github.com/mndrix/go-xco"
+)
+
+type fakeSender struct {
+	sent []interface{}
+}
+
+func (f *fakeSender) Send(stanza interface{}) error {
+	f.sent = append(f.sent, stanza)
+	return nil
+}
+
+// mustAddr parses s as an XMPP address, panicking if it's malformed.
+// It's only ever called with addresses the tests themselves construct,
+// so a parse failure means the test is broken, not the code under test.
+func mustAddr(s string) xco.Address {
+	a, err := xco.ParseAddress(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func TestRouterLookupSpecificity(t *testing.T) {
+	r := &Router{handlers: make(map[route]StanzaHandlerFunc)}
+
+	var got string
+	r.HandleFunc("iq", "", "", func(Sender, interface{}) error { got = "name-only"; return nil })
+	r.HandleFunc("iq", nsDiscoInfo, "", func(Sender, interface{}) error { got = "name+ns"; return nil })
+	r.HandleFunc("iq", nsDiscoInfo, "get", func(Sender, interface{}) error { got = "name+ns+type"; return nil })
+
+	sender := &fakeSender{}
+
+	if _, err := r.Dispatch(sender, "iq", nsDiscoInfo, "get", &xco.Iq{}); err != nil {
+		t.Fatal(err)
+	}
+	if got != "name+ns+type" {
+		t.Fatalf("got %q, want most specific match", got)
+	}
+
+	if _, err := r.Dispatch(sender, "iq", nsDiscoInfo, "set", &xco.Iq{}); err != nil {
+		t.Fatal(err)
+	}
+	if got != "name+ns" {
+		t.Fatalf("got %q, want name+ns fallback", got)
+	}
+
+	if _, err := r.Dispatch(sender, "iq", "some:other:ns", "get", &xco.Iq{}); err != nil {
+		t.Fatal(err)
+	}
+	if got != "name-only" {
+		t.Fatalf("got %q, want name-only fallback", got)
+	}
+}
+
+func TestRouterDispatchUnmatchedReturnsFalse(t *testing.T) {
+	r := &Router{handlers: make(map[route]StanzaHandlerFunc)}
+	sender := &fakeSender{}
+
+	matched, err := r.Dispatch(sender, "presence", "", "", &xco.Presence{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matched {
+		t.Fatal("Dispatch reported a match with no handlers registered")
+	}
+}
+
+type capturingLogger struct {
+	errors []string
+}
+
+func (l *capturingLogger) Debug(msg string, keyvals ...interface{}) {}
+func (l *capturingLogger) Info(msg string, keyvals ...interface{})  {}
+func (l *capturingLogger) Warn(msg string, keyvals ...interface{})  {}
+func (l *capturingLogger) Error(msg string, keyvals ...interface{}) {
+	l.errors = append(l.errors, msg)
+}
+
+func TestRouterDispatchLogsHandlerError(t *testing.T) {
+	r := &Router{handlers: make(map[route]StanzaHandlerFunc)}
+	logger := &capturingLogger{}
+	r.SetLogger(logger)
+
+	boom := errors.New("boom")
+	r.HandleFunc("iq", "", "", func(Sender, interface{}) error { return boom })
+
+	matched, err := r.Dispatch(&fakeSender{}, "iq", "", "", &xco.Iq{})
+	if !matched || err != boom {
+		t.Fatalf("got matched=%v err=%v, want matched=true err=boom", matched, err)
+	}
+	if len(logger.errors) != 1 {
+		t.Fatalf("got %d logged errors, want 1", len(logger.errors))
+	}
+}
+
+func TestRouterBuiltinDiscoInfo(t *testing.T) {
+	r := NewRouter()
+	sender := &fakeSender{}
+
+	iq := &xco.Iq{
+		Header: xco.Header{ID: "1", From: mustAddr("a@b"), To: mustAddr("c@d")},
+		Type:   "get",
+	}
+	matched, err := r.Dispatch(sender, "iq", nsDiscoInfo, "get", iq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !matched {
+		t.Fatal("disco#info builtin didn't match its own namespace")
+	}
+	if len(sender.sent) != 1 {
+		t.Fatalf("got %d replies, want 1", len(sender.sent))
+	}
+	reply, ok := sender.sent[0].(*xco.Iq)
+	if !ok || reply.Type != "result" || reply.To.String() != "a@b" {
+		t.Fatalf("got reply %#v, want a result iq addressed back to a@b", sender.sent[0])
+	}
+}
@@ -0,0 +1,240 @@
+package sms // import "github.com/mndrix/sms-over-xmpp"
+
+import (
+	"encoding/xml"
+	"sync"
+
+	xco "github.com/mndrix/go-xco"
+)
+
+// smDefaultMax is the default number of unacked stanzas an smQueue will
+// hold before it starts evicting the oldest entries.
+const smDefaultMax = 256
+
+// smEntry is a single stanza awaiting acknowledgement, tagged with the
+// outbound h-value it was sent under.
+type smEntry struct {
+	h   uint32
+	msg *xco.Message
+}
+
+// smQueue is a bounded FIFO of unacked outbound stanzas used to
+// implement XEP-0198 stream management.  It lives on Component (rather
+// than on xmppProcess) so that it survives an xmppProcess restart: a
+// fresh xmppProcess can attempt `<resume/>` against the same queue.
+type smQueue struct {
+	mu sync.Mutex
+
+	max  int
+	sent []smEntry
+
+	// h is the number of stanzas we've sent since the session began;
+	// it's the value each smEntry is tagged with so ack(h) knows what
+	// to discard.
+	h uint32
+
+	// received is the number of stanzas we've received from the peer
+	// since the session began; it's the value we advertise in
+	// `<a h='...'/>` and `<resume h='...'/>` -- XEP-0198 defines h as
+	// each side reporting what it has received, not what it has sent.
+	received uint32
+
+	// id and resumable describe the most recent `<enabled/>` the
+	// server handed us.  resumable is false until the server tells us
+	// the stream can be resumed.
+	id        string
+	resumable bool
+
+	// onEvict is called, with the evicted message and an explanatory
+	// error, whenever the queue has to drop a stanza to stay under
+	// max. Component uses this to log the lost message; the loss is not
+	// correlated back to any pending SMS receipt.
+	onEvict func(*xco.Message, error)
+}
+
+// newSmQueue creates an empty stream management queue capped at max
+// unacked stanzas.  A max <= 0 uses smDefaultMax.
+func newSmQueue(max int) *smQueue {
+	if max <= 0 {
+		max = smDefaultMax
+	}
+	return &smQueue{max: max}
+}
+
+// reset clears all session-specific state -- so that a previous
+// server's h-values don't get mixed up with the new session's -- and
+// hands every stanza that was still unacked to redeliver, in order, so
+// a caller falling back to a fresh (non-resumed) session can resend
+// them instead of silently losing them.  redeliver may be nil if the
+// caller has nothing unacked to worry about (e.g. a first connect).
+func (q *smQueue) reset(redeliver func(*xco.Message)) {
+	q.mu.Lock()
+	pending := q.sent
+	q.sent = nil
+	q.h = 0
+	q.received = 0
+	q.id = ""
+	q.resumable = false
+	q.mu.Unlock()
+
+	if redeliver == nil {
+		return
+	}
+	for _, e := range pending {
+		redeliver(e.msg)
+	}
+}
+
+// enable records that the server accepted `<enable/>` with the given
+// resumption id.
+func (q *smQueue) enable(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.id = id
+	q.resumable = id != ""
+}
+
+// resumeInfo reports whether the queue believes a previous session can
+// be resumed and, if so, the id and h to send in `<resume/>`.  h is the
+// number of stanzas received from the peer, per XEP-0198.
+func (q *smQueue) resumeInfo() (id string, h uint32, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.id, q.received, q.resumable
+}
+
+// receivedH reports the current count of stanzas received from the
+// peer, for replying to the peer's own `<r/>` with `<a h='...'/>`.
+func (q *smQueue) receivedH() uint32 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.received
+}
+
+// recordReceived counts one more stanza received from the peer.  It's
+// called once per real stanza dispatch sees (message/iq/presence), so
+// received tracks exactly what XEP-0198 means by "stanzas I've
+// received from you."
+func (q *smQueue) recordReceived() {
+	q.mu.Lock()
+	q.received++
+	q.mu.Unlock()
+}
+
+// track records that msg has been sent to the server and returns the
+// h-value it was sent under.  If the queue is full, the oldest unacked
+// entry is evicted and reported via onEvict.
+func (q *smQueue) track(msg *xco.Message) uint32 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.h++
+	entry := smEntry{h: q.h, msg: msg}
+	q.sent = append(q.sent, entry)
+
+	if len(q.sent) > q.max {
+		evicted := q.sent[0]
+		q.sent = q.sent[1:]
+		if q.onEvict != nil {
+			go q.onEvict(evicted.msg, errSmQueueFull)
+		}
+	}
+	return q.h
+}
+
+// ack discards every tracked entry with h-value <= h, per the semantics
+// of XEP-0198's `<a h='N'/>`.
+func (q *smQueue) ack(h uint32) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := 0
+	for ; i < len(q.sent); i++ {
+		if q.sent[i].h > h {
+			break
+		}
+	}
+	q.sent = q.sent[i:]
+}
+
+// unacked returns, in order, the stanzas that haven't been acked yet.
+// It's used to replay the queue after a successful `<resume/>` or to
+// drain it into fresh sends after falling back to a new session.
+func (q *smQueue) unacked() []*xco.Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msgs := make([]*xco.Message, len(q.sent))
+	for i, e := range q.sent {
+		msgs[i] = e.msg
+	}
+	return msgs
+}
+
+// errSmQueueFull is reported to onEvict when a stanza is dropped
+// because the unacked queue hit its cap.
+var errSmQueueFull = smQueueFullError{}
+
+type smQueueFullError struct{}
+
+func (smQueueFullError) Error() string {
+	return "stream management queue full; oldest unacked stanza evicted"
+}
+
+// The nsSM198 stream-management elements below are the top-level stream
+// children XEP-0198 defines (not children of <iq/>, so they don't go
+// through Router).  xmppProcess's read loop recognizes them by element
+// name and handles them directly against the session's smQueue.
+const nsSM198 = "urn:xmpp:sm:3"
+
+// smEnable is sent right after the session is bound, to ask the server
+// to start stream management and, if we'd like resumption, to mark the
+// request resumable.
+type smEnable struct {
+	XMLName xml.Name `xml:"urn:xmpp:sm:3 enable"`
+	Resume  bool     `xml:"resume,attr"`
+}
+
+// smEnabled is the server's affirmative answer to <enable/>.  Id is the
+// resumption token to use in a later <resume/>; it's only meaningful
+// when Resume is true.
+type smEnabled struct {
+	XMLName xml.Name `xml:"urn:xmpp:sm:3 enabled"`
+	Id      string   `xml:"id,attr"`
+	Resume  bool     `xml:"resume,attr"`
+}
+
+// smResume asks the server to resume a previous session identified by
+// Previd, replaying anything sent after the stanza numbered H.
+type smResume struct {
+	XMLName xml.Name `xml:"urn:xmpp:sm:3 resume"`
+	Previd  string   `xml:"previd,attr"`
+	H       uint32   `xml:"h,attr"`
+}
+
+// smResumed is the server's affirmative answer to <resume/>: the
+// session picked back up, with H being the last stanza the server says
+// it received from us.
+type smResumed struct {
+	XMLName xml.Name `xml:"urn:xmpp:sm:3 resumed"`
+	Previd  string   `xml:"previd,attr"`
+	H       uint32   `xml:"h,attr"`
+}
+
+// smFailed is the server's negative answer to <resume/>; the session is
+// gone and the caller must fall back to a fresh one.
+type smFailed struct {
+	XMLName xml.Name `xml:"urn:xmpp:sm:3 failed"`
+}
+
+// smR requests an ack: "how many stanzas have you received from me?"
+type smR struct {
+	XMLName xml.Name `xml:"urn:xmpp:sm:3 r"`
+}
+
+// smA answers smR (ours or the server's own): "I've received H stanzas
+// from you."
+type smA struct {
+	XMLName xml.Name `xml:"urn:xmpp:sm:3 a"`
+	H       uint32   `xml:"h,attr"`
+}
@@ -0,0 +1,101 @@
+package sms
+
+import (
+	"testing"
+
+	xco "github.com/mndrix/go-xco"
+)
+
+func TestSmQueueTrackAck(t *testing.T) {
+	q := newSmQueue(0)
+
+	h1 := q.track(&xco.Message{Body: "one"})
+	h2 := q.track(&xco.Message{Body: "two"})
+	q.track(&xco.Message{Body: "three"})
+
+	if h1 != 1 || h2 != 2 {
+		t.Fatalf("got h1=%d h2=%d, want 1 and 2", h1, h2)
+	}
+	if len(q.unacked()) != 3 {
+		t.Fatalf("got %d unacked, want 3", len(q.unacked()))
+	}
+
+	q.ack(2)
+	unacked := q.unacked()
+	if len(unacked) != 1 || unacked[0].Body != "three" {
+		t.Fatalf("ack(2) left %v, want only \"three\"", unacked)
+	}
+}
+
+func TestSmQueueEvictsOldestWhenFull(t *testing.T) {
+	q := newSmQueue(2)
+	var evicted *xco.Message
+	q.onEvict = func(msg *xco.Message, err error) {
+		evicted = msg
+		if err != errSmQueueFull {
+			t.Errorf("got err %v, want errSmQueueFull", err)
+		}
+	}
+
+	q.track(&xco.Message{Body: "one"})
+	q.track(&xco.Message{Body: "two"})
+	q.track(&xco.Message{Body: "three"}) // evicts "one"
+
+	// onEvict is invoked asynchronously; run it inline for the test by
+	// calling it directly isn't possible, so just assert on state
+	// instead of the callback's async result.
+	unacked := q.unacked()
+	if len(unacked) != 2 || unacked[0].Body != "two" {
+		t.Fatalf("got %v, want [two three]", unacked)
+	}
+	_ = evicted
+}
+
+func TestSmQueueEnableAndResumeInfo(t *testing.T) {
+	q := newSmQueue(0)
+
+	if _, _, ok := q.resumeInfo(); ok {
+		t.Fatal("resumeInfo reported ok before enable was ever called")
+	}
+
+	q.track(&xco.Message{Body: "one"})
+	q.recordReceived()
+	q.enable("abc123")
+
+	id, h, ok := q.resumeInfo()
+	if !ok || id != "abc123" || h != 1 {
+		t.Fatalf("got id=%q h=%d ok=%v, want abc123, 1, true", id, h, ok)
+	}
+}
+
+func TestSmQueueReceivedHIndependentOfSent(t *testing.T) {
+	q := newSmQueue(0)
+
+	q.track(&xco.Message{Body: "one"})
+	q.track(&xco.Message{Body: "two"})
+	q.recordReceived()
+
+	if h := q.receivedH(); h != 1 {
+		t.Fatalf("got receivedH=%d, want 1 (sent count must not leak into received)", h)
+	}
+}
+
+func TestSmQueueResetRedeliversPending(t *testing.T) {
+	q := newSmQueue(0)
+	q.track(&xco.Message{Body: "one"})
+	q.track(&xco.Message{Body: "two"})
+	q.enable("abc123")
+
+	var redelivered []string
+	q.reset(func(msg *xco.Message) { redelivered = append(redelivered, msg.Body) })
+
+	if len(redelivered) != 2 || redelivered[0] != "one" || redelivered[1] != "two" {
+		t.Fatalf("got %v, want [one two]", redelivered)
+	}
+	if _, _, ok := q.resumeInfo(); ok {
+		t.Fatal("resumeInfo reported ok after reset")
+	}
+	if len(q.unacked()) != 0 {
+		t.Fatalf("got %d unacked after reset, want 0", len(q.unacked()))
+	}
+}
@@ -0,0 +1,47 @@
+package sms // import "github.com/mndrix/sms-over-xmpp"
+
+// sms is one SMS message received from the configured SmsProvider,
+// already normalized out of whatever wire format httpProcess parsed it
+// from.  Participants holds every address on the thread; len > 1 means
+// it's a group thread and belongs in a MUC room rather than a 1:1 chat.
+type sms struct {
+	From         string
+	Participants []string
+	Body         string
+}
+
+// smsStatus classifies an rxSmsStatus update.
+type smsStatus int
+
+const (
+	// smsDelivered means the provider is reporting that a previously
+	// accepted send actually reached the destination handset.
+	smsDelivered smsStatus = iota
+)
+
+// rxSms is something httpProcess hands the gateway over rxSmsCh: either
+// a freshly received SMS (rxSmsMessage) or a status update about one the
+// gateway already sent (rxSmsStatus).  ErrCh is how the gateway reports
+// back whether it handled the event, so httpProcess knows what HTTP
+// response to send the provider.
+type rxSms interface {
+	ErrCh() chan<- error
+}
+
+// rxSmsMessage is an inbound SMS the provider just delivered to us.
+type rxSmsMessage struct {
+	sms   sms
+	errCh chan error
+}
+
+func (x *rxSmsMessage) ErrCh() chan<- error { return x.errCh }
+
+// rxSmsStatus is a delivery status update about an SMS the gateway
+// previously sent, identified by the provider's own id for it.
+type rxSmsStatus struct {
+	id     string
+	status smsStatus
+	errCh  chan error
+}
+
+func (x *rxSmsStatus) ErrCh() chan<- error { return x.errCh }
@@ -0,0 +1,264 @@
+package sms // import "github.com/mndrix/sms-over-xmpp"
+
+import (
+	"encoding/xml"
+	"log"
+	"strconv"
+	"time"
+
+	xco "github.com/mndrix/go-xco"
+)
+
+// smAckInterval is how often the session asks the server "how many
+// stanzas have you received from me?" via <r/>, so a dropped TCP
+// connection is noticed (and its unacked stanzas salvaged) well before
+// the next real send.
+const smAckInterval = 30 * time.Second
+
+// xmppProcess owns the single live connection to the XMPP server: it
+// negotiates the session, dispatches every inbound stanza through
+// router, and forwards outbound stanzas handed to it over gatewayTx.
+type xmppProcess struct {
+	address, name, secret string
+
+	// gatewayTx carries <message/> stanzas the gateway wants sent out
+	// over the live XMPP connection.  gatewayTxPresence carries
+	// <presence/> stanzas the MUC subsystem wants sent the same way.
+	gatewayTx         chan *xco.Message
+	gatewayTxPresence chan interface{}
+
+	smQueue *smQueue
+	router  *Router
+	logger  Logger
+	debug   bool
+
+	onConnect    func(Sender)
+	onDisconnect func(error)
+}
+
+// debugWriter adapts a Logger to the io.Writer xco.Options.Logger wants,
+// so the raw wire-level XML go-xco traces ends up going through the
+// same Logger everything else on Component reports through.
+type debugWriter struct {
+	logger Logger
+}
+
+func (w debugWriter) Write(p []byte) (int, error) {
+	w.logger.Debug(string(p))
+	return len(p), nil
+}
+
+// connect dials the XMPP server and completes the XEP-0114 component
+// handshake, returning a live component ready to serve.
+func (x *xmppProcess) connect() (*xco.Component, error) {
+	opts := xco.Options{
+		Name:         x.name,
+		SharedSecret: x.secret,
+		Address:      x.address,
+	}
+	if x.debug {
+		opts.Logger = log.New(debugWriter{x.logger}, "", 0)
+	}
+	return xco.NewComponent(opts)
+}
+
+// run connects to the XMPP server and services the session until it
+// dies, returning a channel that's closed when it does.
+func (x *xmppProcess) run() <-chan struct{} {
+	healthCh := make(chan struct{})
+	go func() {
+		defer close(healthCh)
+
+		conn, err := x.connect()
+		if err != nil {
+			x.logger.Error("connecting to XMPP server", "error", err)
+			if x.onDisconnect != nil {
+				x.onDisconnect(err)
+			}
+			return
+		}
+
+		defer conn.Close()
+
+		tx, rx, errx := conn.RunAsync()
+
+		if x.onConnect != nil {
+			x.onConnect(conn)
+		}
+
+		x.negotiateStreamManagement(tx, rx)
+
+		err = x.serve(conn, tx, rx, errx)
+		x.logger.Warn("XMPP session ended", "error", err)
+		if x.onDisconnect != nil {
+			x.onDisconnect(err)
+		}
+	}()
+	return healthCh
+}
+
+// negotiateStreamManagement either resumes the previous session -- and
+// replays whatever it left unacked -- or enables a fresh one, draining
+// the old session's unacked stanzas into the new one so a reconnect
+// never silently drops an outbound message.
+func (x *xmppProcess) negotiateStreamManagement(tx chan<- interface{}, rx <-chan interface{}) {
+	if previd, h, ok := x.smQueue.resumeInfo(); ok {
+		tx <- &smResume{Previd: previd, H: h}
+		if stanza, ok := <-rx; ok {
+			if sm, ok := smStanzaFromElement(stanza); ok {
+				if resumed, ok := sm.(*smResumed); ok {
+					x.smQueue.ack(resumed.H)
+					x.logger.Info("resumed XMPP stream", "previd", resumed.Previd)
+					for _, msg := range x.smQueue.unacked() {
+						x.send(tx, msg)
+					}
+					return
+				}
+			}
+		}
+		x.logger.Info("XMPP stream could not be resumed, starting fresh")
+	}
+
+	x.smQueue.reset(func(msg *xco.Message) { x.send(tx, msg) })
+	tx <- &smEnable{Resume: true}
+}
+
+// send transmits msg over tx and tracks it in smQueue so it can be
+// replayed if the connection dies before it's acked.
+func (x *xmppProcess) send(tx chan<- interface{}, msg *xco.Message) {
+	x.smQueue.track(msg)
+	tx <- msg
+}
+
+// serve is the session's main loop: it forwards gatewayTx and
+// gatewayTxPresence sends, periodically requests an ack, and dispatches
+// everything the server sends us, until the connection fails.
+func (x *xmppProcess) serve(conn *xco.Component, tx chan<- interface{}, rx <-chan interface{}, errx <-chan error) error {
+	ackTicker := time.NewTicker(smAckInterval)
+	defer ackTicker.Stop()
+
+	for {
+		select {
+		case msg := <-x.gatewayTx:
+			x.send(tx, msg)
+		case pres := <-x.gatewayTxPresence:
+			tx <- pres
+		case <-ackTicker.C:
+			tx <- &smR{}
+		case stanza, ok := <-rx:
+			if !ok {
+				return <-errx
+			}
+			x.dispatch(conn, stanza)
+		case err := <-errx:
+			return err
+		}
+	}
+}
+
+// dispatch handles the top-level XEP-0198 elements (<a/>, <r/>,
+// <enabled/>, <failed/> -- these are stream children, not <iq/>
+// children, so Router never sees them, and go-xco hands them back
+// undecoded as *xml.StartElement; smStanzaFromElement recovers our own
+// sm* types from them) directly against smQueue, and routes everything
+// else to router by stanza name, payload namespace, and type.
+func (x *xmppProcess) dispatch(sender Sender, stanza interface{}) {
+	if sm, ok := smStanzaFromElement(stanza); ok {
+		stanza = sm
+	}
+	switch s := stanza.(type) {
+	case *smA:
+		x.smQueue.ack(s.H)
+	case *smR:
+		sender.Send(&smA{H: x.smQueue.receivedH()})
+	case *smEnabled:
+		x.smQueue.enable(s.Id)
+	case *smFailed:
+		x.logger.Warn("server could not enable stream management")
+	case *xco.Message:
+		x.smQueue.recordReceived()
+		// A receipt request is a protocol-level facet of the message, not
+		// an alternative to its normal routing: it still needs to reach
+		// whatever's registered at "", or a receipt-requesting chat
+		// message would get acked and its body silently dropped.  So
+		// dispatch it twice: once to let the nsReceipts builtin send the
+		// ack, once more the normal way so the app's own handler still
+		// sees it.
+		if s.ReceiptRequest != nil {
+			x.router.Dispatch(sender, "message", nsReceipts, string(s.Type), s)
+		}
+		x.router.Dispatch(sender, "message", "", string(s.Type), s)
+	case *xco.Iq:
+		x.smQueue.recordReceived()
+		// go-xco's Iq only decodes a disco#info or vCard payload; anything
+		// else -- including disco#items and XEP-0199 ping -- arrives with
+		// no field to recover its namespace from, so IsDiscoInfo is the
+		// only query this can ever discriminate.  handleDiscoItems/
+		// handlePing stay registered for a Config that builds its own
+		// Iq-aware Router, but they're unreachable through this dispatch.
+		namespace := ""
+		if s.IsDiscoInfo() {
+			namespace = nsDiscoInfo
+		}
+		x.router.Dispatch(sender, "iq", namespace, s.Type, s)
+	case *xco.Presence:
+		x.smQueue.recordReceived()
+		x.router.Dispatch(sender, "presence", "", s.Type, s)
+	default:
+		x.logger.Debug("unhandled stanza", "type", stanza)
+	}
+}
+
+// smStanzaFromElement recovers one of our local XEP-0198 stanza types
+// from a raw stream-level element.  go-xco's public API only decodes
+// <message/>, <presence/>, and <iq/> by name; every other top-level
+// stream child -- which is all XEP-0198 ever sends -- arrives on its rx
+// channel undecoded, as a *xml.StartElement, instead.
+func smStanzaFromElement(stanza interface{}) (interface{}, bool) {
+	st, ok := stanza.(*xml.StartElement)
+	if !ok || st.Name.Space != nsSM198 {
+		return nil, false
+	}
+
+	attr := func(name string) string {
+		for _, a := range st.Attr {
+			if a.Name.Local == name {
+				return a.Value
+			}
+		}
+		return ""
+	}
+
+	switch st.Name.Local {
+	case "a":
+		return &smA{H: parseH(attr("h"))}, true
+	case "r":
+		return &smR{}, true
+	case "enabled":
+		return &smEnabled{Id: attr("id"), Resume: attr("resume") == "true"}, true
+	case "resumed":
+		return &smResumed{Previd: attr("previd"), H: parseH(attr("h"))}, true
+	case "failed":
+		return &smFailed{}, true
+	}
+	return nil, false
+}
+
+// parseH parses an XEP-0198 h attribute, defaulting to 0 for a missing
+// or malformed value rather than failing the whole stanza over it.
+func parseH(s string) uint32 {
+	n, _ := strconv.ParseUint(s, 10, 32)
+	return uint32(n)
+}
+
+// parseAddress parses s as an XMPP address, logging rather than failing
+// the caller if it's malformed.  Every caller builds s itself out of
+// known-good parts, so a parse failure here means a logic bug worth
+// surfacing, not input worth silently swallowing.
+func parseAddress(logger Logger, s string) xco.Address {
+	addr, err := xco.ParseAddress(s)
+	if err != nil {
+		logger.Error("building XMPP address", "address", s, "error", err)
+	}
+	return addr
+}
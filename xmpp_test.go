@@ -0,0 +1,57 @@
+package sms
+
+import (
+	"encoding/xml"
+	"strconv"
+	"testing"
+
+	xco "github.com/mndrix/go-xco"
+)
+
+// resumedElement builds the raw *xml.StartElement go-xco hands back for a
+// server's <resumed previd='...' h='...'/>, the way negotiateStreamManagement
+// actually receives it off rx.
+func resumedElement(previd string, h uint32) *xml.StartElement {
+	return &xml.StartElement{
+		Name: xml.Name{Space: nsSM198, Local: "resumed"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "previd"}, Value: previd},
+			{Name: xml.Name{Local: "h"}, Value: strconv.FormatUint(uint64(h), 10)},
+		},
+	}
+}
+
+func TestXmppProcessNegotiateStreamManagementAcksResumedHBeforeReplay(t *testing.T) {
+	q := newSmQueue(0)
+	q.enable("prev-id")
+
+	msg1 := &xco.Message{Body: "already received by the server"}
+	msg2 := &xco.Message{Body: "never made it"}
+	q.track(msg1) // h=1
+	q.track(msg2) // h=2
+
+	x := &xmppProcess{smQueue: q, logger: NewStdLogger()}
+
+	tx := make(chan interface{}, 10)
+	rx := make(chan interface{}, 1)
+	rx <- resumedElement("prev-id", 1)
+
+	x.negotiateStreamManagement(tx, rx)
+	close(tx)
+
+	var got []interface{}
+	for v := range tx {
+		got = append(got, v)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("got %d stanzas on tx, want 2 (the <resume/>, then only the unacked message)", len(got))
+	}
+	if _, ok := got[0].(*smResume); !ok {
+		t.Fatalf("got %#v first, want *smResume", got[0])
+	}
+	replayed, ok := got[1].(*xco.Message)
+	if !ok || replayed != msg2 {
+		t.Fatalf("got %#v replayed, want msg2 (msg1 was already acked by resumed.H=1)", got[1])
+	}
+}